@@ -8,6 +8,7 @@ import (
 	"log"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/gopacket"
@@ -15,12 +16,12 @@ import (
 	"github.com/google/gopacket/pcap"
 )
 
-type arpTable struct {
+type ArpTable struct {
 	IP           net.IP
 	HardwareAddr net.HardwareAddr
 }
 
-type arpTables []arpTable
+type ArpTables []ArpTable
 
 type arpStruct struct {
 	iface *net.Interface
@@ -72,12 +73,21 @@ func New(interfaceName string) (arpStruct, error) {
 	return a, nil
 }
 
-// scan scans an individual interface's local network for machines using ARP requests/replies.  scan loops forever, sending packets out regularly.  It returns an error if
-// it's ever unable to write a packet.
-func (a arpStruct) Scan() (arpTables, error) {
+// Scan scans an individual interface's local network for machines using ARP
+// requests/replies, waiting the default 2 seconds for replies and sending
+// each IP exactly once. It's ScanWithOptions with zero-value ScanOptions.
+func (a arpStruct) Scan() (ArpTables, error) {
+	return a.ScanWithOptions(ScanOptions{})
+}
+
+// ScanWithOptions is like Scan, but lets the caller control how long to wait
+// for replies, how many retry passes to make for IPs that haven't answered,
+// and how fast ARP requests go out. It returns an error if it's ever unable
+// to write a packet.
+func (a arpStruct) ScanWithOptions(opts ScanOptions) (ArpTables, error) {
 	// We just look for IPv4 addresses, so try to find if the interface has one.
 	var addr *net.IPNet
-	var at arpTables
+	var at ArpTables
 	addrs, err := a.iface.Addrs()
 	if err != nil {
 		return at, err
@@ -106,62 +116,153 @@ func (a arpStruct) Scan() (arpTables, error) {
 	}
 	log.Printf("Using network range %v for interface %v", addr, a.iface.Name)
 
-	// Open up a pcap handle for packet reads/writes.
-	handle, err := pcap.OpenLive(a.iface.Name, 65536, true, pcap.BlockForever)
+	waitTime := opts.WaitTime
+	if waitTime <= 0 {
+		// We don't know exactly how long it'll take for packets to be sent
+		// back to us, but 2 seconds should be more than enough time ;)
+		waitTime = 2 * time.Second
+	}
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+
+	// Open up a pcap handle for packet reads/writes. We use a short read
+	// timeout (rather than BlockForever) so readARP's receive loop can poll
+	// the stop channel instead of blocking on it forever.
+	handle, err := pcap.OpenLive(a.iface.Name, 65536, true, 500*time.Millisecond)
 	if err != nil {
 		return at, err
 	}
 	defer handle.Close()
 
-	stop := make(chan bool)
-	go readARP(handle, a.iface, &at, stop)
-	defer close(stop)
-	// go readARP(handle, a.iface, &at)
-	if err := writeARP(handle, a.iface, addr); err != nil {
-		log.Printf("error writing packets on %v: %v", a.iface.Name, err)
+	// Let the kernel drop everything except ARP replies before it ever
+	// crosses into userspace -- this matters on noisy LANs or /16 scans.
+	if err := handle.SetBPFFilter("arp and arp[6:2] = 2"); err != nil {
 		return at, err
 	}
-	// We don't know exactly how long it'll take for packets to be
-	// sent back to us, but 2 seconds should be more than enough
-	// time ;)
-	time.Sleep(2 * time.Second)
-	stop <- true
+
+	stop := make(chan bool)
+	done := make(chan struct{})
+	var mu sync.Mutex
+	go readARP(handle, a.iface, &at, &mu, stop, done)
+	// readARP can now exit on its own (e.g. a handle error), not just via
+	// stop, so close(stop) -- which never blocks -- replaces the old
+	// unbuffered send, and we wait on done to know it has actually
+	// returned (and so stopped touching at) before we read at ourselves.
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	pending := ips(addr)
+	for pass := 0; len(pending) > 0; pass++ {
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			break
+		}
+		if err := writeARP(handle, a.iface, addr.IP, pending, opts.InterPacketDelay); err != nil {
+			log.Printf("error writing packets on %v: %v", a.iface.Name, err)
+			return at, err
+		}
+		time.Sleep(waitTime)
+		if pass >= opts.Retries {
+			break
+		}
+		pending = unanswered(pending, &at, &mu)
+	}
 	return at, nil
 }
 
-func readARP(handle *pcap.Handle, iface *net.Interface, arpTables *arpTables, stop chan bool) {
-	src := gopacket.NewPacketSource(handle, layers.LayerTypeEthernet)
-	in := src.Packets()
+// unanswered returns the subset of sent that at doesn't yet have a reply
+// for, so a retry pass only re-sends IPs that are still missing.
+func unanswered(sent []net.IP, at *ArpTables, mu *sync.Mutex) []net.IP {
+	mu.Lock()
+	replied := make(map[string]bool, len(*at))
+	for _, t := range *at {
+		replied[t.IP.String()] = true
+	}
+	mu.Unlock()
+
+	var remaining []net.IP
+	for _, ip := range sent {
+		if !replied[ip.String()] {
+			remaining = append(remaining, ip)
+		}
+	}
+	return remaining
+}
+
+// readARP reads raw frames off handle via ZeroCopyReadPacketData and a
+// pre-allocated DecodingLayerParser, avoiding the per-packet allocations of
+// gopacket.NewPacketSource. Combined with the BPF filter set on handle, this
+// keeps the receive path cheap on noisy LANs or wide /16 scans. It returns
+// when stop is closed or it hits an unrecoverable read error, closing done
+// either way so the caller can tell it has actually stopped.
+func readARP(handle *pcap.Handle, iface *net.Interface, tables *ArpTables, mu *sync.Mutex, stop chan bool, done chan struct{}) {
+	defer close(done)
+
+	var eth layers.Ethernet
+	var arp layers.ARP
+	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &arp)
+	// Real replies carry Ethernet padding after the ARP body, which decodes as
+	// a Payload layer we have no decoder for; that is not an error here.
+	parser.IgnoreUnsupported = true
+	decoded := make([]gopacket.LayerType, 0, 2)
+
 	for {
-		var packet gopacket.Packet
 		select {
 		case <-stop:
 			return
-		case packet = <-in:
-			arpLayer := packet.Layer(layers.LayerTypeARP)
-			if arpLayer == nil {
-				continue
-			}
-			arp := arpLayer.(*layers.ARP)
-			if arp.Operation != layers.ARPReply || bytes.Equal([]byte(iface.HardwareAddr), arp.SourceHwAddress) {
-				// This is a packet I sent.
+		default:
+		}
+		data, _, err := handle.ZeroCopyReadPacketData()
+		if err != nil {
+			if err == pcap.NextErrorTimeoutExpired {
 				continue
 			}
-			// Note:  we might get some packets here that aren't responses to ones we've sent,
-			// if for example someone else sends US an ARP request.  Doesn't much matter, though...
-			// all information is good information :)
-			*arpTables = append(*arpTables, arpTable{
-				IP:           net.IP(arp.SourceProtAddress),
-				HardwareAddr: net.HardwareAddr(arp.SourceHwAddress),
-			})
-			// log.Printf("IP %v is at %v", net.IP(arp.SourceProtAddress), net.HardwareAddr(arp.SourceHwAddress))
+			return
+		}
+		if err := parser.DecodeLayers(data, &decoded); err != nil {
+			// Non-ARP or malformed packet; nothing to do.
+			continue
+		}
+		if !containsARP(decoded) || arp.Operation != layers.ARPReply || bytes.Equal([]byte(iface.HardwareAddr), arp.SourceHwAddress) {
+			// Not an ARP reply, or one of our own packets.
+			continue
+		}
+		// Note:  we might get some packets here that aren't responses to ones we've sent,
+		// if for example someone else sends US an ARP request.  Doesn't much matter, though...
+		// all information is good information :)
+		//
+		// arp.SourceProtAddress/SourceHwAddress are slices into data, which
+		// ZeroCopyReadPacketData invalidates on the next call -- copy them
+		// out before storing, or every host after the first ends up
+		// aliasing whatever packet we read most recently.
+		ip := append(net.IP(nil), arp.SourceProtAddress...)
+		mac := append(net.HardwareAddr(nil), arp.SourceHwAddress...)
+		mu.Lock()
+		*tables = append(*tables, ArpTable{
+			IP:           ip,
+			HardwareAddr: mac,
+		})
+		mu.Unlock()
+	}
+}
+
+func containsARP(decoded []gopacket.LayerType) bool {
+	for _, t := range decoded {
+		if t == layers.LayerTypeARP {
+			return true
 		}
 	}
+	return false
 }
 
-// writeARP writes an ARP request for each address on our local network to the
-// pcap handle.
-func writeARP(handle *pcap.Handle, iface *net.Interface, addr *net.IPNet) error {
+// writeARP writes an ARP request, sourced from srcIP, for each address in
+// targets to the pcap handle. If delay is positive, it's used as the
+// minimum gap between packets (via a time.Ticker) to avoid flooding
+// switches or wireless links that rate-limit or drop bursty ARP traffic.
+func writeARP(handle *pcap.Handle, iface *net.Interface, srcIP net.IP, targets []net.IP, delay time.Duration) error {
 	// Set up all the layers' fields we can.
 	eth := layers.Ethernet{
 		SrcMAC:       iface.HardwareAddr,
@@ -175,7 +276,7 @@ func writeARP(handle *pcap.Handle, iface *net.Interface, addr *net.IPNet) error
 		ProtAddressSize:   4,
 		Operation:         layers.ARPRequest,
 		SourceHwAddress:   []byte(iface.HardwareAddr),
-		SourceProtAddress: []byte(addr.IP),
+		SourceProtAddress: []byte(srcIP),
 		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
 	}
 	// Set up buffer and options for serialization.
@@ -184,8 +285,17 @@ func writeARP(handle *pcap.Handle, iface *net.Interface, addr *net.IPNet) error
 		FixLengths:       true,
 		ComputeChecksums: true,
 	}
+
+	var ticker *time.Ticker
+	if delay > 0 {
+		ticker = time.NewTicker(delay)
+		defer ticker.Stop()
+	}
 	// Send one packet for every address.
-	for _, ip := range ips(addr) {
+	for i, ip := range targets {
+		if ticker != nil && i > 0 {
+			<-ticker.C
+		}
 		arp.DstProtAddress = []byte(ip)
 		gopacket.SerializeLayers(buf, opts, &eth, &arp)
 		if err := handle.WritePacketData(buf.Bytes()); err != nil {
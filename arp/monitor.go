@@ -0,0 +1,178 @@
+package arp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// EventType identifies the kind of Event emitted by Monitor.
+type EventType int
+
+const (
+	// EventNewHost fires the first time an IP is observed.
+	EventNewHost EventType = iota
+	// EventMACChanged fires when an IP's hardware address changes, which on
+	// a stable LAN usually means ARP spoofing or a MAC flap.
+	EventMACChanged
+	// EventStale fires when a previously-seen host hasn't been observed
+	// again within MonitorOptions.StaleAfter.
+	EventStale
+)
+
+// Event describes a single change observed while monitoring.
+type Event struct {
+	Type      EventType
+	IP        net.IP
+	MAC       net.HardwareAddr // current (or, for EventStale, last-known) MAC
+	OldMAC    net.HardwareAddr // set only for EventMACChanged
+	Timestamp time.Time
+}
+
+// MonitorOptions configures Monitor.
+type MonitorOptions struct {
+	// StaleAfter is how long a host may go unseen before an EventStale is
+	// emitted for it. Zero disables staleness checks.
+	StaleAfter time.Duration
+	// ActiveProbe, when true, makes Monitor send its own ARP request for the
+	// sender's IP whenever it observes an ARP request from another host,
+	// confirming the mapping instead of only ever listening passively.
+	// Observed replies do not trigger a probe.
+	ActiveProbe bool
+}
+
+type hostState struct {
+	mac      net.HardwareAddr
+	lastSeen time.Time
+}
+
+// Monitor keeps a pcap handle open indefinitely, watching every ARP packet
+// seen on the wire (requests and replies alike) and emitting an Event any
+// time it sees a new host, a host's MAC change, or (if opts.StaleAfter is
+// set) a host go quiet. Monitor blocks until ctx is done or it hits an
+// unrecoverable error.
+func (a arpStruct) Monitor(ctx context.Context, opts MonitorOptions, events chan<- Event) error {
+	handle, err := pcap.OpenLive(a.iface.Name, 65536, true, pcap.BlockForever)
+	if err != nil {
+		return err
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter("arp"); err != nil {
+		return err
+	}
+
+	hosts := make(map[string]hostState)
+
+	var staleTick <-chan time.Time
+	if opts.StaleAfter > 0 {
+		ticker := time.NewTicker(opts.StaleAfter)
+		defer ticker.Stop()
+		staleTick = ticker.C
+	}
+
+	src := gopacket.NewPacketSource(handle, layers.LayerTypeEthernet)
+	in := src.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-staleTick:
+			for ip, h := range hosts {
+				if now.Sub(h.lastSeen) >= opts.StaleAfter {
+					events <- Event{Type: EventStale, IP: net.ParseIP(ip), MAC: h.mac, Timestamp: now}
+				}
+			}
+		case packet, ok := <-in:
+			if !ok {
+				return errors.New("packet source closed")
+			}
+			arpLayer := packet.Layer(layers.LayerTypeARP)
+			if arpLayer == nil {
+				continue
+			}
+			reply := arpLayer.(*layers.ARP)
+			if bytes.Equal([]byte(a.iface.HardwareAddr), reply.SourceHwAddress) {
+				continue // a packet we sent
+			}
+			a.observe(handle, hosts, reply, opts, events)
+		}
+	}
+}
+
+// observe updates hosts with the sender in reply, emitting EventNewHost or
+// EventMACChanged as appropriate, and, when opts.ActiveProbe is set and the
+// packet is an ARP request, re-ARPs the sender to confirm the mapping.
+func (a arpStruct) observe(handle *pcap.Handle, hosts map[string]hostState, reply *layers.ARP, opts MonitorOptions, events chan<- Event) {
+	ip := net.IP(reply.SourceProtAddress)
+	mac := net.HardwareAddr(reply.SourceHwAddress)
+	now := time.Now()
+
+	prev, known := hosts[ip.String()]
+	switch {
+	case !known:
+		events <- Event{Type: EventNewHost, IP: ip, MAC: mac, Timestamp: now}
+	case !bytes.Equal(prev.mac, mac):
+		events <- Event{Type: EventMACChanged, IP: ip, MAC: mac, OldMAC: prev.mac, Timestamp: now}
+	}
+	hosts[ip.String()] = hostState{mac: mac, lastSeen: now}
+
+	if opts.ActiveProbe && reply.Operation == layers.ARPRequest {
+		// Send our own ARP request for ip to confirm the mapping, rather
+		// than trusting the observed request/reply alone.
+		_ = a.probe(handle, ip)
+	}
+}
+
+// probe sends a single ARP request for ip. It doesn't wait for a reply;
+// Monitor's own read loop will see it (and classify any change) like any
+// other ARP traffic on the wire.
+func (a arpStruct) probe(handle *pcap.Handle, ip net.IP) error {
+	src := a.localIPv4()
+	if src == nil {
+		return nil
+	}
+	eth := layers.Ethernet{
+		SrcMAC:       a.iface.HardwareAddr,
+		DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   []byte(a.iface.HardwareAddr),
+		SourceProtAddress: []byte(src),
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    []byte(ip.To4()),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &arp); err != nil {
+		return err
+	}
+	return handle.WritePacketData(buf.Bytes())
+}
+
+// localIPv4 returns a.iface's IPv4 address, or nil if it has none.
+func (a arpStruct) localIPv4() net.IP {
+	addrs, err := a.iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4
+			}
+		}
+	}
+	return nil
+}
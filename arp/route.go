@@ -0,0 +1,117 @@
+package arp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/routing"
+)
+
+// ScanTarget resolves the hardware address to use when sending packets to ip,
+// even when ip lives outside any local interface's subnet.  It consults the
+// OS routing table to find the outgoing interface, gateway, and source IP for
+// ip (mirroring gopacket's synscan example), then ARPs the gateway -- or ip
+// itself, if it's directly reachable -- to learn the MAC to use on the wire.
+//
+// Unlike Scan, ScanTarget has no opinion about the size of the destination
+// network; routing, not a local /16 sanity check, decides reachability.
+func ScanTarget(ip net.IP) (ArpTables, error) {
+	router, err := routing.New()
+	if err != nil {
+		return nil, fmt.Errorf("opening routing table: %v", err)
+	}
+	iface, gw, src, err := router.Route(ip)
+	if err != nil {
+		return nil, fmt.Errorf("routing to %v: %v", ip, err)
+	}
+	if len(iface.HardwareAddr) == 0 {
+		return nil, errors.New("could not obtain MAC address")
+	}
+
+	handle, err := pcap.OpenLive(iface.Name, 65536, true, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+
+	// ARP the gateway if ip isn't on-link; otherwise ARP ip directly.
+	arpTarget := ip
+	if gw != nil {
+		arpTarget = gw
+	}
+	mac, err := resolveHWAddr(handle, iface, src, arpTarget)
+	if err != nil {
+		return nil, err
+	}
+	return ArpTables{{IP: ip, HardwareAddr: mac}}, nil
+}
+
+// resolveHWAddr sends ARP requests for dst (sourced from src) until it gets a
+// reply or times out.
+func resolveHWAddr(handle *pcap.Handle, iface *net.Interface, src, dst net.IP) (net.HardwareAddr, error) {
+	eth := layers.Ethernet{
+		SrcMAC:       iface.HardwareAddr,
+		DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   []byte(iface.HardwareAddr),
+		SourceProtAddress: []byte(src.To4()),
+		DstHwAddress:      []byte{0, 0, 0, 0, 0, 0},
+		DstProtAddress:    []byte(dst.To4()),
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &arp); err != nil {
+		return nil, err
+	}
+
+	// Build a single packet source and reuse it across retries -- each call
+	// to NewPacketSource spins up its own background reader goroutine on
+	// handle, so creating one per retry pass would leak goroutines racing
+	// each other for reads.
+	packetSource := gopacket.NewPacketSource(handle, layers.LayerTypeEthernet)
+	in := packetSource.Packets()
+
+	src4 := dst.To4()
+	const retries = 3
+	const perPassTimeout = time.Second
+	for retry := 0; retry < retries; retry++ {
+		if err := handle.WritePacketData(buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("writing ARP request: %v", err)
+		}
+		deadline := time.After(perPassTimeout)
+	readLoop:
+		for {
+			select {
+			case packet := <-in:
+				arpLayer := packet.Layer(layers.LayerTypeARP)
+				if arpLayer == nil {
+					continue
+				}
+				reply := arpLayer.(*layers.ARP)
+				if reply.Operation != layers.ARPReply {
+					continue
+				}
+				if !bytes.Equal(reply.SourceProtAddress, src4) {
+					continue
+				}
+				return net.HardwareAddr(reply.SourceHwAddress), nil
+			case <-deadline:
+				break readLoop
+			}
+		}
+	}
+	return nil, fmt.Errorf("timed out waiting for ARP reply from %v", dst)
+}
@@ -0,0 +1,82 @@
+package arp
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ScanOptions controls both how ScanAll fans out across interfaces and how
+// each individual interface is scanned (see ScanWithOptions).
+type ScanOptions struct {
+	// MaxConcurrency caps how many interfaces ScanAll scans at once. Zero
+	// (the default) means unlimited, i.e. one goroutine per interface --
+	// fine for a handful of interfaces, but machines with many virtual
+	// interfaces may want to cap this to avoid exhausting pcap handles.
+	MaxConcurrency int
+
+	// WaitTime is how long to wait for replies after each send pass. Zero
+	// means the default of 2 seconds.
+	WaitTime time.Duration
+	// Retries is how many additional send passes to make for IPs that
+	// haven't replied yet. Zero means send each IP once, as before.
+	Retries int
+	// InterPacketDelay, if positive, is the minimum gap enforced between
+	// outgoing ARP requests, to avoid overrunning switches or wireless
+	// links that rate-limit or drop bursty ARP traffic.
+	InterPacketDelay time.Duration
+	// Timeout, if positive, is checked before each send pass of a single
+	// interface's scan: once it has elapsed, no further pass is started. A
+	// pass already under way (its sends and its WaitTime) is not cut short.
+	Timeout time.Duration
+}
+
+// ScanAll scans every interface named in interfaceNames (typically the
+// output of IfaceToName) in parallel, one goroutine per interface, and
+// returns each interface's results keyed by interface name. It's a
+// first-class equivalent of looping over interfaceNames and calling
+// ScanWithOptions yourself, with opts.MaxConcurrency to bound how many
+// interfaces are scanned at once; the rest of opts is passed through
+// unchanged to each interface's ScanWithOptions call. Errors from individual
+// interfaces are joined together and returned alongside whatever results
+// were collected.
+func ScanAll(interfaceNames []string, opts ScanOptions) (map[string]ArpTables, error) {
+	results := make(map[string]ArpTables, len(interfaceNames))
+	var mu sync.Mutex
+	var errs []error
+
+	sem := make(chan struct{}, opts.MaxConcurrency)
+	if opts.MaxConcurrency <= 0 {
+		sem = make(chan struct{}, len(interfaceNames))
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range interfaceNames {
+		name := name
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			a, err := New(name)
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			at, err := a.ScanWithOptions(opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			results[name] = at
+		}()
+	}
+	wg.Wait()
+
+	return results, errors.Join(errs...)
+}
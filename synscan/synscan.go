@@ -0,0 +1,345 @@
+// Package synscan implements a small TCP SYN port scanner that scans the
+// hosts discovered by package arp.  It mirrors the design of gopacket's
+// synscan example, adapted into a reusable library on top of this module's
+// ARP discovery: for every arp.ArpTable entry, it crafts raw Ethernet+IPv4+TCP
+// SYN packets addressed at the host's already-known MAC, and classifies each
+// port from the reply (or lack of one).
+package synscan
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"github.com/ssstl/arp-scan-X/arp"
+)
+
+// PortState describes the outcome of probing a single port.
+type PortState int
+
+const (
+	// StateFiltered means no reply was seen before the timeout.
+	StateFiltered PortState = iota
+	// StateOpen means a SYN/ACK was received.
+	StateOpen
+	// StateClosed means a RST was received.
+	StateClosed
+)
+
+func (s PortState) String() string {
+	switch s {
+	case StateOpen:
+		return "open"
+	case StateClosed:
+		return "closed"
+	default:
+		return "filtered"
+	}
+}
+
+// PortResult is the classification of a single port on a single host.
+type PortResult struct {
+	IP    net.IP
+	Port  uint16
+	State PortState
+}
+
+// Scanner SYN-scans a fixed set of targets and ports over a single interface.
+type Scanner struct {
+	iface   *net.Interface
+	targets arp.ArpTables
+	ports   []uint16
+
+	// PacketsPerSecond caps the rate at which SYNs are sent per host. Zero
+	// means unlimited.
+	PacketsPerSecond int
+	// PerPortTimeout bounds how long we wait for a reply before marking a
+	// port filtered. Defaults to 2s if zero.
+	PerPortTimeout time.Duration
+}
+
+// New returns a Scanner that will SYN-scan ports on targets, sending packets
+// out of interfaceName.
+func New(interfaceName string, targets arp.ArpTables, ports []uint16) (*Scanner, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("interface %v: unkown", interfaceName)
+	}
+	if len(iface.HardwareAddr) == 0 {
+		return nil, fmt.Errorf("interface %v has no hardware address", interfaceName)
+	}
+	return &Scanner{
+		iface:          iface,
+		targets:        targets,
+		ports:          ports,
+		PerPortTimeout: 2 * time.Second,
+	}, nil
+}
+
+// Scan runs the SYN scan, one goroutine per host, and returns the
+// classification of every probed port once all hosts have been scanned or
+// ctx is done. A port whose SYN could not be sent, or that was not yet
+// probed when ctx is done, has no entry in the result.
+func (s *Scanner) Scan(ctx context.Context) ([]PortResult, error) {
+	handle, err := pcap.OpenLive(s.iface.Name, 65536, true, pcap.BlockForever)
+	if err != nil {
+		return nil, err
+	}
+	defer handle.Close()
+	if err := handle.SetBPFFilter("tcp and (tcp[13] & 0x12 != 0)"); err != nil {
+		return nil, fmt.Errorf("setting BPF filter: %v", err)
+	}
+
+	results := newResultSink(handle, s.iface, s.localIP())
+
+	// Run results.read under its own cancelable context, and wait for it to
+	// actually exit before we return (and handle.Close fires): ctx is the
+	// caller's context and usually isn't canceled just because the scan
+	// below has finished, so without this read would leak for as long as
+	// ctx lives, racing handle.Close on its way out.
+	readCtx, cancelRead := context.WithCancel(ctx)
+	defer cancelRead()
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		results.read(readCtx)
+	}()
+
+	var wg sync.WaitGroup
+	for _, target := range s.targets {
+		target := target
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.scanHost(ctx, handle, results, target)
+		}()
+	}
+	wg.Wait()
+
+	cancelRead()
+	<-readDone
+
+	return results.drain(), nil
+}
+
+func (s *Scanner) scanHost(ctx context.Context, handle *pcap.Handle, results *resultSink, target arp.ArpTable) {
+	var ticker *time.Ticker
+	if s.PacketsPerSecond > 0 {
+		ticker = time.NewTicker(time.Second / time.Duration(s.PacketsPerSecond))
+		defer ticker.Stop()
+	}
+
+	timeout := s.PerPortTimeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	srcIP, srcPort := s.localAddrFor(target.IP)
+	for _, port := range s.ports {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := s.sendSYN(handle, target, srcIP, srcPort, port); err != nil {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		state := results.awaitState(ctx, target.IP, port, srcPort, timeout)
+		results.record(PortResult{IP: target.IP, Port: port, State: state})
+	}
+}
+
+// localAddrFor picks s.iface's IPv4 address and a randomized ephemeral
+// source port for the scan of dst.
+func (s *Scanner) localAddrFor(dst net.IP) (net.IP, uint16) {
+	return s.localIP(), uint16(1024 + rand.Intn(64512))
+}
+
+// localIP returns s.iface's IPv4 address, or nil if it has none.
+func (s *Scanner) localIP() net.IP {
+	addrs, _ := s.iface.Addrs()
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok {
+			if ip4 := ipnet.IP.To4(); ip4 != nil {
+				return ip4
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) sendSYN(handle *pcap.Handle, target arp.ArpTable, srcIP net.IP, srcPort uint16, port uint16) error {
+	eth := layers.Ethernet{
+		SrcMAC:       s.iface.HardwareAddr,
+		DstMAC:       target.HardwareAddr,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip4 := layers.IPv4{
+		SrcIP:    srcIP,
+		DstIP:    target.IP,
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+	}
+	tcp := layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(port),
+		SYN:     true,
+		Seq:     rand.Uint32(),
+		Window:  14600,
+	}
+	tcp.SetNetworkLayerForChecksum(&ip4)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip4, &tcp); err != nil {
+		return err
+	}
+	return handle.WritePacketData(buf.Bytes())
+}
+
+// resultSink reads TCP replies off handle and lets scanHost goroutines wait
+// for the reply that answers their probe.
+type resultSink struct {
+	handle  *pcap.Handle
+	iface   *net.Interface
+	localIP net.IP
+
+	mu      sync.Mutex
+	waiters map[string]chan PortState
+	// pending holds replies that arrived before awaitState registered a
+	// waiter for their key, so a fast reply (or one racing the waiter
+	// registration) isn't dropped on the floor.
+	pending map[string]PortState
+	results []PortResult
+}
+
+func newResultSink(handle *pcap.Handle, iface *net.Interface, localIP net.IP) *resultSink {
+	return &resultSink{
+		handle:  handle,
+		iface:   iface,
+		localIP: localIP,
+		waiters: make(map[string]chan PortState),
+		pending: make(map[string]PortState),
+	}
+}
+
+// replyKey folds in ourPort -- the source port our probe used -- alongside
+// the target's IP and port, so a reply only matches the probe that's
+// actually addressed back to it. Both sides derive it independently: the
+// sender knows ourPort when it registers a waiter, and read computes the
+// same key straight from the incoming packet's destination port.
+func replyKey(ip net.IP, port, ourPort uint16) string {
+	return fmt.Sprintf("%s:%d:%d", ip.String(), port, ourPort)
+}
+
+func (r *resultSink) read(ctx context.Context) {
+	src := gopacket.NewPacketSource(r.handle, layers.LayerTypeEthernet)
+	in := src.Packets()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case packet, ok := <-in:
+			if !ok {
+				return
+			}
+			ipLayer := packet.Layer(layers.LayerTypeIPv4)
+			tcpLayer := packet.Layer(layers.LayerTypeTCP)
+			if ipLayer == nil || tcpLayer == nil {
+				continue
+			}
+			ip4 := ipLayer.(*layers.IPv4)
+			tcp := tcpLayer.(*layers.TCP)
+			// The BPF filter matches any SYN or ACK on the wire, which on a
+			// promiscuous live LAN is most established traffic -- only
+			// packets actually addressed back to us can be a reply to one
+			// of our own probes.
+			if !ip4.DstIP.Equal(r.localIP) {
+				continue
+			}
+			state := StateClosed
+			if tcp.SYN && tcp.ACK {
+				state = StateOpen
+			} else if !tcp.RST {
+				continue
+			}
+			r.deliver(ip4.SrcIP, uint16(tcp.SrcPort), uint16(tcp.DstPort), state)
+		}
+	}
+}
+
+// deliver hands state to whichever scanHost goroutine is waiting for
+// ip:port:ourPort. If awaitState hasn't registered a waiter for it yet --
+// the reply can easily beat the registration on a quiet LAN -- state is
+// buffered in pending so the upcoming awaitState call picks it up instead of
+// blocking until it times out for a port that actually answered.
+func (r *resultSink) deliver(ip net.IP, port, ourPort uint16, state PortState) {
+	key := replyKey(ip, port, ourPort)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ch, ok := r.waiters[key]; ok {
+		select {
+		case ch <- state:
+		default:
+			// Already has a buffered reply (e.g. a duplicate); keep the
+			// first one and drop this one rather than blocking here.
+		}
+		return
+	}
+	r.pending[key] = state
+}
+
+func (r *resultSink) awaitState(ctx context.Context, ip net.IP, port, srcPort uint16, timeout time.Duration) PortState {
+	key := replyKey(ip, port, srcPort)
+
+	r.mu.Lock()
+	if state, ok := r.pending[key]; ok {
+		delete(r.pending, key)
+		r.mu.Unlock()
+		return state
+	}
+	ch := make(chan PortState, 1)
+	r.waiters[key] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.waiters, key)
+		r.mu.Unlock()
+	}()
+
+	select {
+	case state := <-ch:
+		return state
+	case <-time.After(timeout):
+		return StateFiltered
+	case <-ctx.Done():
+		return StateFiltered
+	}
+}
+
+func (r *resultSink) record(res PortResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, res)
+}
+
+func (r *resultSink) drain() []PortResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.results
+}